@@ -0,0 +1,243 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/timestreamquery"
+
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timestream returns timestamps in this format, e.g. 2021-11-15 20:40:31.123000000
+const timestreamTimeLayout = "2006-01-02 15:04:05.999999999"
+
+// GrafanaAnnotation is the body accepted by the Grafana HTTP API at /api/annotations
+type GrafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd,omitempty"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+func main() {
+	region := flag.String("region", "us-east-1", "region of the Timestream database")
+	annotationQuery := flag.String("annotation-query", "", "query selecting the events to export as annotations")
+	timeField := flag.String("annotation-time-field", "time", "column holding the annotation start time")
+	timeEndField := flag.String("annotation-time-end-field", "", "column holding the annotation end time, optional")
+	titleField := flag.String("annotation-title-field", "", "column holding the annotation text")
+	tagsField := flag.String("annotation-tags-field", "", "column holding comma separated annotation tags, optional")
+	outputFile := flag.String("output-file", "", "file to write the annotations to as a JSON array")
+	grafanaUrl := flag.String("grafana-url", "", "Grafana base URL to POST the annotations to, e.g. http://localhost:3000")
+	grafanaApiKey := flag.String("grafana-api-key", "", "Grafana API key or service account token")
+	flag.Parse()
+
+	if *annotationQuery == "" || *titleField == "" {
+		fmt.Println("Both --annotation-query and --annotation-title-field are required")
+		os.Exit(1)
+	}
+	if *outputFile == "" && *grafanaUrl == "" {
+		fmt.Println("One of --output-file or --grafana-url is required")
+		os.Exit(1)
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(*region)})
+	if err != nil {
+		fmt.Println("Error creating session:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	querySvc := timestreamquery.New(sess)
+
+	annotations, err := queryAnnotations(querySvc, *annotationQuery, *timeField, *timeEndField, *titleField, *tagsField)
+	if err != nil {
+		fmt.Println("Error while querying annotations:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Number of annotations:", len(annotations))
+
+	if *outputFile != "" {
+		if err := writeAnnotationsFile(*outputFile, annotations); err != nil {
+			fmt.Println("Error while writing annotations file:")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Annotations written to", *outputFile)
+	}
+
+	if *grafanaUrl != "" {
+		if err := postAnnotations(*grafanaUrl, *grafanaApiKey, annotations); err != nil {
+			fmt.Println("Error while posting annotations to Grafana:")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Annotations posted to", *grafanaUrl)
+	}
+}
+
+func queryAnnotations(querySvc *timestreamquery.TimestreamQuery, query string,
+	timeField string, timeEndField string, titleField string, tagsField string) ([]GrafanaAnnotation, error) {
+	queryInput := &timestreamquery.QueryInput{
+		QueryString: aws.String(query),
+	}
+
+	annotations := []GrafanaAnnotation{}
+	firstPage := true
+	rowNumber := 0
+	var pageErr error
+	err := querySvc.QueryPages(queryInput,
+		func(page *timestreamquery.QueryOutput, lastPage bool) bool {
+			if firstPage {
+				firstPage = false
+				if pageErr = checkAnnotationColumns(page.ColumnInfo, timeField, timeEndField, titleField, tagsField); pageErr != nil {
+					return false
+				}
+			}
+			for _, row := range page.Rows {
+				rowNumber++
+				annotation, err := rowToAnnotation(page.ColumnInfo, row, timeField, timeEndField, titleField, tagsField)
+				if err != nil {
+					pageErr = fmt.Errorf("row %d: %w", rowNumber, err)
+					return false
+				}
+				annotations = append(annotations, annotation)
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	if pageErr != nil {
+		return nil, pageErr
+	}
+	return annotations, nil
+}
+
+// Fails on configured fields missing from the result, so a misspelled optional field
+// is not mistaken for a NULL value and silently dropped from every annotation
+func checkAnnotationColumns(columnInfo []*timestreamquery.ColumnInfo,
+	timeField string, timeEndField string, titleField string, tagsField string) error {
+	columns := make(map[string]*timestreamquery.ColumnInfo)
+	for _, column := range columnInfo {
+		columns[*column.Name] = column
+	}
+	for _, field := range []string{timeField, timeEndField, titleField, tagsField} {
+		if field != "" && columns[field] == nil {
+			return fmt.Errorf("column %q not found in query result", field)
+		}
+	}
+	// Time fields are parsed with the TIMESTAMP layout, DATE or VARCHAR values would not parse
+	for _, field := range []string{timeField, timeEndField} {
+		if field == "" {
+			continue
+		}
+		scalarType := columns[field].Type.ScalarType
+		if scalarType == nil {
+			return fmt.Errorf("column %q must be of type TIMESTAMP, got a non scalar type", field)
+		}
+		if *scalarType != timestreamquery.ScalarTypeTimestamp {
+			return fmt.Errorf("column %q must be of type TIMESTAMP, got %s", field, *scalarType)
+		}
+	}
+	return nil
+}
+
+func rowToAnnotation(columnInfo []*timestreamquery.ColumnInfo, row *timestreamquery.Row,
+	timeField string, timeEndField string, titleField string, tagsField string) (GrafanaAnnotation, error) {
+	// Only scalar columns are mapped, nested types are not meaningful as annotation attributes
+	values := make(map[string]string)
+	for i, column := range columnInfo {
+		datum := row.Data[i]
+		if datum.ScalarValue != nil {
+			values[*column.Name] = *datum.ScalarValue
+		}
+	}
+
+	var annotation GrafanaAnnotation
+	startTime, ok := values[timeField]
+	if !ok {
+		return annotation, fmt.Errorf("column %q is missing or null", timeField)
+	}
+	start, err := time.Parse(timestreamTimeLayout, startTime)
+	if err != nil {
+		return annotation, fmt.Errorf("column %q: %w", timeField, err)
+	}
+	annotation.Time = start.UnixMilli()
+
+	if timeEndField != "" {
+		if endTime, ok := values[timeEndField]; ok {
+			end, err := time.Parse(timestreamTimeLayout, endTime)
+			if err != nil {
+				return annotation, fmt.Errorf("column %q: %w", timeEndField, err)
+			}
+			annotation.TimeEnd = end.UnixMilli()
+		}
+	}
+
+	text, ok := values[titleField]
+	if !ok {
+		return annotation, fmt.Errorf("column %q is missing or null", titleField)
+	}
+	annotation.Text = text
+
+	if tagsField != "" && values[tagsField] != "" {
+		for _, tag := range strings.Split(values[tagsField], ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				annotation.Tags = append(annotation.Tags, tag)
+			}
+		}
+	}
+	return annotation, nil
+}
+
+func writeAnnotationsFile(path string, annotations []GrafanaAnnotation) error {
+	data, err := json.MarshalIndent(annotations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func postAnnotations(grafanaUrl string, apiKey string, annotations []GrafanaAnnotation) error {
+	// The Grafana annotations API accepts a single annotation per request
+	endpoint := strings.TrimRight(grafanaUrl, "/") + "/api/annotations"
+	client := &http.Client{Timeout: 20 * time.Second}
+	for i, annotation := range annotations {
+		if err := postAnnotation(client, endpoint, apiKey, annotation); err != nil {
+			// Annotations posted so far are kept by Grafana, re-running posts them again
+			return fmt.Errorf("posted %d of %d annotations: %w", i, len(annotations), err)
+		}
+	}
+	return nil
+}
+
+func postAnnotation(client *http.Client, endpoint string, apiKey string, annotation GrafanaAnnotation) error {
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("grafana returned %s for annotation %q", resp.Status, annotation.Text)
+	}
+	return nil
+}